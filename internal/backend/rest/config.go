@@ -0,0 +1,58 @@
+package rest
+
+import (
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/options"
+)
+
+// Config contains all configuration necessary to connect to a REST server.
+type Config struct {
+	URL         *url.URL
+	Connections uint `option:"connections" help:"set a limit for the number of concurrent connections (default: 5)"`
+
+	MaxRetries      uint          `option:"retries" help:"set the number of retries for transient errors (default: 5)"`
+	RetryBackoff    time.Duration `option:"retry-backoff" help:"set the base delay for the retry backoff (default: 500ms)"`
+	RetryBackoffCap time.Duration `option:"retry-backoff-cap" help:"set the maximum delay between retries (default: 30s)"`
+
+	ContentHash bool `option:"content-hash" help:"verify a SHA-256 content hash on Save/Load, for servers that support it (default: true)"`
+}
+
+// NewConfig returns a new Config with the default values filled in.
+func NewConfig() Config {
+	return Config{
+		Connections:     5,
+		MaxRetries:      5,
+		RetryBackoff:    500 * time.Millisecond,
+		RetryBackoffCap: 30 * time.Second,
+		ContentHash:     true,
+	}
+}
+
+func init() {
+	options.Register("rest", Config{})
+}
+
+// ParseConfig parses the string s and extracts the REST server URL.
+func ParseConfig(s string) (interface{}, error) {
+	if !strings.HasPrefix(s, "rest:") {
+		return nil, errors.New("invalid REST backend specification")
+	}
+
+	s = s[len("rest:"):]
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, errors.Wrap(err, "url.Parse")
+	}
+
+	if u.Path == "" {
+		u.Path = "/"
+	}
+
+	cfg := NewConfig()
+	cfg.URL = u
+	return cfg, nil
+}
@@ -0,0 +1,105 @@
+package rest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"io/ioutil"
+
+	"github.com/restic/restic/internal/restic"
+)
+
+// contentSHA256Header carries the hex-encoded SHA-256 digest of a file's
+// contents, as a header on Load responses and a trailer on Save requests.
+const contentSHA256Header = "X-Content-SHA256"
+
+// hashingReader wraps an io.Reader, computing a running SHA-256 digest of
+// the bytes read through it and reporting it via onEOF once exhausted.
+type hashingReader struct {
+	io.Reader
+	hash  hash.Hash
+	onEOF func(sum string)
+	done  bool
+}
+
+func newHashingReader(rd io.Reader, onEOF func(sum string)) *hashingReader {
+	h := sha256.New()
+	return &hashingReader{
+		Reader: io.TeeReader(rd, h),
+		hash:   h,
+		onEOF:  onEOF,
+	}
+}
+
+func (h *hashingReader) Read(p []byte) (int, error) {
+	n, err := h.Reader.Read(p)
+	if err == io.EOF && !h.done {
+		h.done = true
+		if h.onEOF != nil {
+			h.onEOF(hex.EncodeToString(h.hash.Sum(nil)))
+		}
+	}
+	return n, err
+}
+
+// verifyingReadCloser wraps an io.ReadCloser, checking the SHA-256 digest
+// of the bytes read against an expected value once it is exhausted. A
+// mismatch surfaces as ErrCorrupted from Read once it observes the end of
+// the data, and again from Close, since callers that read into a pre-sized
+// buffer (e.g. io.ReadFull) may never see EOF from Read, or may discard the
+// error returned along with the final bytes.
+type verifyingReadCloser struct {
+	io.ReadCloser
+	hash     hash.Hash
+	expected string
+	handle   restic.Handle
+	done     bool
+	err      error
+}
+
+func newVerifyingReadCloser(rc io.ReadCloser, expected string, h restic.Handle) io.ReadCloser {
+	return &verifyingReadCloser{
+		ReadCloser: rc,
+		hash:       sha256.New(),
+		expected:   expected,
+		handle:     h,
+	}
+}
+
+func (v *verifyingReadCloser) Read(p []byte) (int, error) {
+	n, err := v.ReadCloser.Read(p)
+	if n > 0 {
+		v.hash.Write(p[:n])
+	}
+
+	if err == io.EOF && !v.done {
+		v.done = true
+		if sum := hex.EncodeToString(v.hash.Sum(nil)); sum != v.expected {
+			v.err = ErrCorrupted{Handle: v.handle}
+			return n, v.err
+		}
+	}
+
+	return n, err
+}
+
+// Close drains any unread remainder so the digest reflects the whole file,
+// then verifies it before delegating to the underlying Close. This catches
+// callers like io.ReadFull that stop reading once their buffer is full and
+// so never drive Read to observe io.EOF itself.
+func (v *verifyingReadCloser) Close() error {
+	if !v.done {
+		if _, err := io.Copy(ioutil.Discard, v); err != nil {
+			_ = v.ReadCloser.Close()
+			return err
+		}
+	}
+
+	if v.err != nil {
+		_ = v.ReadCloser.Close()
+		return v.err
+	}
+
+	return v.ReadCloser.Close()
+}
@@ -0,0 +1,189 @@
+package rest
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+)
+
+func testHandle() restic.Handle {
+	id := sha256.Sum256([]byte("test"))
+	return restic.Handle{Type: restic.DataFile, Name: hex.EncodeToString(id[:])}
+}
+
+func TestSaveLoadContentHashRoundtrip(t *testing.T) {
+	var stored []byte
+	var sentSum string
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "POST":
+			body, _ := ioutil.ReadAll(r.Body)
+			stored = body
+			sentSum = r.Trailer.Get(contentSHA256Header)
+		case "GET":
+			sum := sha256.Sum256(stored)
+			w.Header().Set(contentSHA256Header, hex.EncodeToString(sum[:]))
+			_, _ = w.Write(stored)
+		}
+	}))
+	defer srv.Close()
+
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := NewConfig()
+	cfg.URL = u
+
+	be, err := Open(cfg, http.DefaultTransport)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := []byte("hello world")
+	h := testHandle()
+
+	if err := be.Save(context.Background(), h, bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+
+	wantSum := sha256.Sum256(data)
+	if sentSum != hex.EncodeToString(wantSum[:]) {
+		t.Errorf("Save() trailer hash = %v, want %v", sentSum, hex.EncodeToString(wantSum[:]))
+	}
+
+	rd, err := be.Load(context.Background(), h, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rd.Close()
+
+	got, err := ioutil.ReadAll(rd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("Load() = %q, want %q", got, data)
+	}
+}
+
+func TestLoadContentHashMismatch(t *testing.T) {
+	data := []byte("hello world")
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(contentSHA256Header, hex.EncodeToString(make([]byte, sha256.Size)))
+		_, _ = w.Write(data)
+	}))
+	defer srv.Close()
+
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := NewConfig()
+	cfg.URL = u
+
+	be, err := Open(cfg, http.DefaultTransport)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rd, err := be.Load(context.Background(), testHandle(), 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rd.Close()
+
+	_, err = ioutil.ReadAll(rd)
+	if _, ok := errors.Cause(err).(ErrCorrupted); !ok {
+		t.Errorf("expected ErrCorrupted for a hash mismatch, got %v", err)
+	}
+}
+
+func TestLoadContentHashMismatchOnClose(t *testing.T) {
+	data := []byte("hello world")
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(contentSHA256Header, hex.EncodeToString(make([]byte, sha256.Size)))
+		_, _ = w.Write(data)
+	}))
+	defer srv.Close()
+
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := NewConfig()
+	cfg.URL = u
+
+	be, err := Open(cfg, http.DefaultTransport)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rd, err := be.Load(context.Background(), testHandle(), 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// io.ReadFull into a pre-sized buffer can return before Read ever
+	// observes io.EOF, or drop the error Read returns with the final bytes,
+	// so the mismatch must still be caught on Close.
+	buf := make([]byte, len(data))
+	if _, err := io.ReadFull(rd, buf); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := errors.Cause(rd.Close()).(ErrCorrupted); !ok {
+		t.Errorf("expected ErrCorrupted from Close() for a hash mismatch")
+	}
+}
+
+func TestLoadRangedReadSkipsVerification(t *testing.T) {
+	data := []byte("hello world")
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sum := sha256.Sum256(data)
+		w.Header().Set(contentSHA256Header, hex.EncodeToString(sum[:]))
+		w.WriteHeader(http.StatusPartialContent)
+		_, _ = w.Write(data[:5])
+	}))
+	defer srv.Close()
+
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := NewConfig()
+	cfg.URL = u
+
+	be, err := Open(cfg, http.DefaultTransport)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rd, err := be.Load(context.Background(), testHandle(), 5, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rd.Close()
+
+	got, err := ioutil.ReadAll(rd)
+	if err != nil {
+		t.Fatalf("partial read was wrongly verified against the full-file hash: %v", err)
+	}
+	if !bytes.Equal(got, data[:5]) {
+		t.Errorf("Load() = %q, want %q", got, data[:5])
+	}
+}
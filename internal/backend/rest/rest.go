@@ -6,12 +6,14 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"mime"
 	"net/http"
 	"net/url"
 	"path"
 	"strings"
 
 	"golang.org/x/net/context/ctxhttp"
+	"golang.org/x/sync/errgroup"
 
 	"github.com/restic/restic/internal/debug"
 	"github.com/restic/restic/internal/errors"
@@ -25,6 +27,7 @@ var _ restic.Backend = &restBackend{}
 
 type restBackend struct {
 	url    *url.URL
+	cfg    Config
 	sem    *backend.Semaphore
 	client *http.Client
 	backend.Layout
@@ -47,6 +50,7 @@ func Open(cfg Config, rt http.RoundTripper) (restic.Backend, error) {
 
 	be := &restBackend{
 		url:    cfg.URL,
+		cfg:    cfg,
 		client: client,
 		Layout: &backend.RESTLayout{URL: url, Join: path.Join},
 		sem:    sem,
@@ -108,11 +112,36 @@ func (b *restBackend) Save(ctx context.Context, h restic.Handle, rd io.Reader) (
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
-	// make sure that client.Post() cannot close the reader by wrapping it
-	rd = ioutil.NopCloser(rd)
+	body, err := newRewindableReader(rd)
+	if err != nil {
+		return errors.Wrap(err, "newRewindableReader")
+	}
 
 	b.sem.GetToken()
-	resp, err := ctxhttp.Post(ctx, b.client, b.Filename(h), "binary/octet-stream", rd)
+	resp, err := doWithRetry(ctx, b.cfg, func() (*http.Response, error) {
+		if err := body.rewind(); err != nil {
+			return nil, err
+		}
+
+		req, err := http.NewRequest("POST", b.Filename(h), nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "binary/octet-stream")
+		req.ContentLength = -1
+
+		// make sure that the transport cannot close the reader by wrapping it
+		if b.cfg.ContentHash {
+			req.Trailer = http.Header{contentSHA256Header: nil}
+			req.Body = ioutil.NopCloser(newHashingReader(body, func(sum string) {
+				req.Trailer.Set(contentSHA256Header, sum)
+			}))
+		} else {
+			req.Body = ioutil.NopCloser(body)
+		}
+
+		return ctxhttp.Do(ctx, b.client, req)
+	})
 	b.sem.ReleaseToken()
 
 	if resp != nil {
@@ -154,6 +183,18 @@ func (b *restBackend) IsNotExist(err error) bool {
 	return ok
 }
 
+// ErrCorrupted is returned by the reader that Load returns (from Read at the
+// end of the data, and from Close) when the X-Content-SHA256 digest sent by
+// the server does not match the bytes actually received, which indicates
+// silent corruption somewhere between the server and restic.
+type ErrCorrupted struct {
+	restic.Handle
+}
+
+func (e ErrCorrupted) Error() string {
+	return fmt.Sprintf("%v: content hash mismatch, data is corrupted", e.Handle)
+}
+
 // Load returns a reader that yields the contents of the file at h at the
 // given offset. If length is nonzero, only a portion of the file is
 // returned. rd must be closed after use.
@@ -184,7 +225,9 @@ func (b *restBackend) Load(ctx context.Context, h restic.Handle, length int, off
 	debug.Log("Load(%v) send range %v", h, byteRange)
 
 	b.sem.GetToken()
-	resp, err := ctxhttp.Do(ctx, b.client, req)
+	resp, err := doWithRetry(ctx, b.cfg, func() (*http.Response, error) {
+		return ctxhttp.Do(ctx, b.client, req)
+	})
 	b.sem.ReleaseToken()
 
 	if err != nil {
@@ -205,6 +248,14 @@ func (b *restBackend) Load(ctx context.Context, h restic.Handle, length int, off
 		return nil, errors.Errorf("unexpected HTTP response (%v): %v", resp.StatusCode, resp.Status)
 	}
 
+	// X-Content-SHA256 is the digest of the whole stored file, so it can
+	// only verify full, unranged reads.
+	if b.cfg.ContentHash && resp.StatusCode == 200 && offset == 0 && length == 0 {
+		if sum := resp.Header.Get(contentSHA256Header); sum != "" {
+			return newVerifyingReadCloser(resp.Body, sum, h), nil
+		}
+	}
+
 	return resp.Body, nil
 }
 
@@ -215,7 +266,9 @@ func (b *restBackend) Stat(ctx context.Context, h restic.Handle) (restic.FileInf
 	}
 
 	b.sem.GetToken()
-	resp, err := ctxhttp.Head(ctx, b.client, b.Filename(h))
+	resp, err := doWithRetry(ctx, b.cfg, func() (*http.Response, error) {
+		return ctxhttp.Head(ctx, b.client, b.Filename(h))
+	})
 	b.sem.ReleaseToken()
 	if err != nil {
 		return restic.FileInfo{}, errors.Wrap(err, "client.Head")
@@ -267,7 +320,9 @@ func (b *restBackend) Remove(ctx context.Context, h restic.Handle) error {
 		return errors.Wrap(err, "http.NewRequest")
 	}
 	b.sem.GetToken()
-	resp, err := ctxhttp.Do(ctx, b.client, req)
+	resp, err := doWithRetry(ctx, b.cfg, func() (*http.Response, error) {
+		return ctxhttp.Do(ctx, b.client, req)
+	})
 	b.sem.ReleaseToken()
 
 	if err != nil {
@@ -297,69 +352,222 @@ func (b *restBackend) Remove(ctx context.Context, h restic.Handle) error {
 func (b *restBackend) List(ctx context.Context, t restic.FileType) <-chan string {
 	ch := make(chan string)
 
-	url := b.Dirname(restic.Handle{Type: t})
-	if !strings.HasSuffix(url, "/") {
-		url += "/"
-	}
+	go func() {
+		defer close(ch)
+		for entry := range b.List2(ctx, t) {
+			select {
+			case ch <- entry.Name:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
 
-	b.sem.GetToken()
-	resp, err := ctxhttp.Get(ctx, b.client, url)
-	b.sem.ReleaseToken()
+	return ch
+}
+
+// Close closes all open files.
+func (b *restBackend) Close() error {
+	// this does not need to do anything, all open files are closed within the
+	// same function.
+	return nil
+}
+
+// mimeTypeRESTv1 is the content type of a plain JSON array of names,
+// returned by servers that don't understand mimeTypeRESTv2.
+const mimeTypeRESTv1 = "application/json"
+
+// mimeTypeRESTv2 is the content type of a JSON array of {name, size}
+// objects, requested via the Accept header on directory listings.
+const mimeTypeRESTv2 = "application/vnd.x.restic.rest.v2"
+
+// RESTFileInfo is the per-file metadata returned by a v2 directory listing.
+type RESTFileInfo struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+}
+
+// listPageSize is the number of entries requested per page from servers
+// that support pagination.
+const listPageSize = 10000
+
+// List2 works like List, but additionally returns the size of each file, as
+// reported by a v2 REST server; Size is left at zero on v1 servers. Pages
+// are fetched and decoded one at a time and only then streamed to the
+// returned channel, so a slow or blocked consumer never holds up a
+// semaphore token: the token taken for each page is released as soon as
+// fetchPage returns, before its entries are handed off.
+func (b *restBackend) List2(ctx context.Context, t restic.FileType) <-chan RESTFileInfo {
+	ch := make(chan RESTFileInfo)
+
+	go func() {
+		defer close(ch)
+
+		url := b.Dirname(restic.Handle{Type: t})
+		if !strings.HasSuffix(url, "/") {
+			url += "/"
+		}
+		url += fmt.Sprintf("?limit=%d", listPageSize)
+
+		for url != "" {
+			b.sem.GetToken()
+			entries, next, err := b.fetchPage(ctx, url)
+			b.sem.ReleaseToken()
+
+			if err != nil {
+				debug.Log("List2(%v): %v", t, err)
+				return
+			}
+
+			for _, entry := range entries {
+				select {
+				case ch <- entry:
+				case <-ctx.Done():
+					return
+				}
+			}
+
+			url = next
+		}
+	}()
+
+	return ch
+}
+
+// fetchPage requests and fully decodes a single page of a directory
+// listing from pageURL, and returns the URL of the next page, if any. The
+// caller's semaphore token only needs to cover this call, not whatever the
+// caller does with the result: a consumer that also needs a token (e.g. to
+// remove each listed file) must never be made to wait on a token the lister
+// is still holding, or the two would deadlock against each other.
+func (b *restBackend) fetchPage(ctx context.Context, pageURL string) (entries []RESTFileInfo, next string, err error) {
+	req, err := http.NewRequest("GET", pageURL, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	// ask for the v2 listing format, but accept a v1 response from older
+	// servers; isRESTv2ContentType sorts out which one we got back.
+	req.Header.Add("Accept", mimeTypeRESTv2)
 
+	resp, err := doWithRetry(ctx, b.cfg, func() (*http.Response, error) {
+		return ctxhttp.Do(ctx, b.client, req)
+	})
 	if resp != nil {
 		defer func() {
 			_, _ = io.Copy(ioutil.Discard, resp.Body)
-			e := resp.Body.Close()
-
-			if err == nil {
-				err = errors.Wrap(e, "Close")
-			}
+			_ = resp.Body.Close()
 		}()
 	}
-
 	if err != nil {
-		close(ch)
-		return ch
+		return nil, "", err
+	}
+
+	if resp.StatusCode != 200 {
+		return nil, "", errors.Errorf("unexpected HTTP response (%v): %v", resp.StatusCode, resp.Status)
+	}
+
+	contentType := resp.Header.Get("Content-Type")
+	isV2 := isRESTv2ContentType(contentType)
+	if !isV2 && !isRESTv1ContentType(contentType) {
+		debug.Log("fetchPage(%v): unexpected content type %q, assuming v1", pageURL, contentType)
 	}
 
 	dec := json.NewDecoder(resp.Body)
-	var list []string
-	if err = dec.Decode(&list); err != nil {
-		close(ch)
-		return ch
+	if _, err := dec.Token(); err != nil { // consume the opening '['
+		return nil, "", errors.Wrap(err, "Token")
 	}
 
-	go func() {
-		defer close(ch)
-		for _, m := range list {
-			select {
-			case ch <- m:
-			case <-ctx.Done():
-				return
+	for dec.More() {
+		var entry RESTFileInfo
+		if isV2 {
+			if err := dec.Decode(&entry); err != nil {
+				return nil, "", errors.Wrap(err, "Decode")
+			}
+		} else {
+			var name string
+			if err := dec.Decode(&name); err != nil {
+				return nil, "", errors.Wrap(err, "Decode")
 			}
+			entry = RESTFileInfo{Name: name}
 		}
-	}()
 
-	return ch
+		entries = append(entries, entry)
+	}
+
+	if isV2 {
+		next = nextPageURL(resp.Header)
+	}
+
+	return entries, next, nil
 }
 
-// Close closes all open files.
-func (b *restBackend) Close() error {
-	// this does not need to do anything, all open files are closed within the
-	// same function.
-	return nil
+// nextPageURL extracts the URL of the next page from a Link: rel="next"
+// response header, as sent by v2 servers that paginate large listings.
+func nextPageURL(h http.Header) string {
+	for _, link := range h["Link"] {
+		for _, part := range strings.Split(link, ",") {
+			segs := strings.Split(part, ";")
+			if len(segs) < 2 {
+				continue
+			}
+
+			for _, seg := range segs[1:] {
+				if strings.TrimSpace(seg) == `rel="next"` {
+					return strings.Trim(strings.TrimSpace(segs[0]), "<>")
+				}
+			}
+		}
+	}
+
+	return ""
 }
 
-// Remove keys for a specified backend type.
+// isRESTv2ContentType returns true if contentType indicates a v2 REST
+// server response.
+func isRESTv2ContentType(contentType string) bool {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mt == mimeTypeRESTv2
+}
+
+// isRESTv1ContentType returns true if contentType indicates a v1 REST
+// server response.
+func isRESTv1ContentType(contentType string) bool {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mt == mimeTypeRESTv1
+}
+
+// removeKeys removes all files of the given type, using a fixed-size pool
+// of cfg.Connections workers pulling names off List so that goroutine count
+// tracks concurrency, not the number of files listed. This relies on List
+// never holding a semaphore token while a worker is waiting to receive from
+// it, or the two would deadlock against each other at cfg.Connections == 1.
 func (b *restBackend) removeKeys(ctx context.Context, t restic.FileType) error {
-	for key := range b.List(ctx, restic.DataFile) {
-		err := b.Remove(ctx, restic.Handle{Type: restic.DataFile, Name: key})
-		if err != nil {
-			return err
-		}
+	g, ctx := errgroup.WithContext(ctx)
+
+	names := b.List(ctx, t)
+	workers := b.cfg.Connections
+	if workers == 0 {
+		workers = 1
 	}
 
-	return nil
+	for i := uint(0); i < workers; i++ {
+		g.Go(func() error {
+			for name := range names {
+				if err := b.Remove(ctx, restic.Handle{Type: t, Name: name}); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+
+	return g.Wait()
 }
 
 // Delete removes all data in the backend.
@@ -374,7 +582,7 @@ func (b *restBackend) Delete(ctx context.Context) error {
 	for _, t := range alltypes {
 		err := b.removeKeys(ctx, t)
 		if err != nil {
-			return nil
+			return err
 		}
 	}
 
@@ -0,0 +1,157 @@
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/restic"
+)
+
+func newTestBackend(t *testing.T, handler http.HandlerFunc) (*restBackend, *httptest.Server) {
+	srv := httptest.NewServer(handler)
+
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := NewConfig()
+	cfg.URL = u
+
+	be, err := Open(cfg, http.DefaultTransport)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return be.(*restBackend), srv
+}
+
+func TestListV1(t *testing.T) {
+	names := []string{"foo", "bar", "baz"}
+
+	be, srv := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", mimeTypeRESTv1)
+		_ = json.NewEncoder(w).Encode(names)
+	})
+	defer srv.Close()
+
+	var got []string
+	for entry := range be.List2(context.Background(), restic.DataFile) {
+		if entry.Size != 0 {
+			t.Errorf("entry %v: expected zero size from a v1 server, got %v", entry.Name, entry.Size)
+		}
+		got = append(got, entry.Name)
+	}
+
+	if !reflect.DeepEqual(got, names) {
+		t.Errorf("List2() = %v, want %v", got, names)
+	}
+}
+
+func TestListV2(t *testing.T) {
+	entries := []RESTFileInfo{{Name: "foo", Size: 1}, {Name: "bar", Size: 2}}
+
+	be, srv := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", mimeTypeRESTv2)
+		_ = json.NewEncoder(w).Encode(entries)
+	})
+	defer srv.Close()
+
+	var got []RESTFileInfo
+	for entry := range be.List2(context.Background(), restic.DataFile) {
+		got = append(got, entry)
+	}
+
+	if !reflect.DeepEqual(got, entries) {
+		t.Errorf("List2() = %v, want %v", got, entries)
+	}
+}
+
+func TestListV2Pagination(t *testing.T) {
+	pages := [][]RESTFileInfo{
+		{{Name: "a", Size: 1}},
+		{{Name: "b", Size: 2}},
+	}
+
+	be, srv := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		page := 0
+		if r.URL.Query().Get("page") == "1" {
+			page = 1
+		}
+
+		if page == 0 {
+			next := fmt.Sprintf("http://%s%s?page=1", r.Host, r.URL.Path)
+			w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"`, next))
+		}
+		w.Header().Set("Content-Type", mimeTypeRESTv2)
+		_ = json.NewEncoder(w).Encode(pages[page])
+	})
+	defer srv.Close()
+
+	var got []RESTFileInfo
+	for entry := range be.List2(context.Background(), restic.DataFile) {
+		got = append(got, entry)
+	}
+
+	want := append(append([]RESTFileInfo{}, pages[0]...), pages[1]...)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("List2() = %v, want %v", got, want)
+	}
+}
+
+func TestDeleteSingleConnection(t *testing.T) {
+	names := []string{"foo", "bar", "baz"}
+	var removed []string
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "DELETE":
+			removed = append(removed, path.Base(r.URL.Path))
+		case "HEAD":
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			w.Header().Set("Content-Type", mimeTypeRESTv1)
+			_ = json.NewEncoder(w).Encode(names)
+		}
+	}))
+	defer srv.Close()
+
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := NewConfig()
+	cfg.URL = u
+	cfg.Connections = 1
+
+	be, err := Open(cfg, http.DefaultTransport)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- be.Delete(context.Background()) }()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Delete() did not return with a single connection; removeKeys and List appear to have deadlocked")
+	}
+
+	// one DELETE per name for each of the 5 file types, plus the config file
+	want := len(names)*5 + 1
+	if len(removed) != want {
+		t.Errorf("got %d removals, want %d", len(removed), want)
+	}
+}
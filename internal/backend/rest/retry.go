@@ -0,0 +1,148 @@
+package rest
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+)
+
+// isRetryableStatusCode returns true for HTTP status codes worth retrying.
+func isRetryableStatusCode(code int) bool {
+	switch code {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return code >= 500
+}
+
+// isRetryableError returns true for transport-level errors worth retrying.
+// Context cancellation is never retryable.
+func isRetryableError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if err == context.Canceled || err == context.DeadlineExceeded {
+		return false
+	}
+	return true
+}
+
+// retryDelay computes the jittered backoff delay for the given 0-based
+// attempt, honoring a Retry-After header on resp when present.
+func retryDelay(cfg Config, attempt int, resp *http.Response) time.Duration {
+	capDelay := cfg.RetryBackoffCap
+	if capDelay <= 0 {
+		capDelay = 30 * time.Second
+	}
+
+	if resp != nil {
+		if ra := resp.Header.Get("Retry-After"); ra != "" {
+			if secs, err := strconv.Atoi(ra); err == nil {
+				d := time.Duration(secs) * time.Second
+				if d > capDelay {
+					d = capDelay
+				}
+				return d
+			}
+		}
+	}
+
+	base := cfg.RetryBackoff
+	if base <= 0 {
+		base = 500 * time.Millisecond
+	}
+
+	delay := base << uint(attempt)
+	if delay <= 0 || delay > capDelay {
+		delay = capDelay
+	}
+
+	// full jitter, so that concurrent clients back off at different times
+	return time.Duration(rand.Int63n(int64(delay) + 1))
+}
+
+// doWithRetry executes do, retrying transient failures with exponential
+// backoff up to cfg.MaxRetries times. do must perform exactly one HTTP
+// round-trip per call.
+func doWithRetry(ctx context.Context, cfg Config, do func() (*http.Response, error)) (*http.Response, error) {
+	for attempt := uint(0); ; attempt++ {
+		resp, err := do()
+
+		retryable := isRetryableError(err) || (err == nil && resp != nil && isRetryableStatusCode(resp.StatusCode))
+		if !retryable || attempt >= cfg.MaxRetries {
+			return resp, err
+		}
+
+		delay := retryDelay(cfg, int(attempt), resp)
+		if resp != nil {
+			_, _ = io.Copy(ioutil.Discard, resp.Body)
+			_ = resp.Body.Close()
+		}
+
+		debug.Log("retrying request after error %v, attempt %d, delay %v", err, attempt, delay)
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// maxRewindableBufferSize is the largest body newRewindableReader will
+// buffer into memory to make a non-seekable reader replayable. Above this,
+// buffering the whole body just to support retries isn't worth the memory,
+// so the reader is used as-is and can't be rewound a second time.
+const maxRewindableBufferSize = 8 * 1024 * 1024
+
+// rewindableReader wraps a reader so it can be replayed from the start on a
+// retry, buffering it into memory first if it isn't already seekable.
+type rewindableReader struct {
+	io.Reader
+	seeker  io.Seeker
+	rewound bool
+}
+
+// newRewindableReader returns a rewindableReader for rd.
+func newRewindableReader(rd io.Reader) (*rewindableReader, error) {
+	if seeker, ok := rd.(io.Seeker); ok {
+		return &rewindableReader{Reader: rd, seeker: seeker}, nil
+	}
+
+	buf, err := ioutil.ReadAll(io.LimitReader(rd, maxRewindableBufferSize+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(buf) <= maxRewindableBufferSize {
+		r := bytes.NewReader(buf)
+		return &rewindableReader{Reader: r, seeker: r}, nil
+	}
+
+	// too large to buffer: read the rest straight through, but without a
+	// real seeker this reader can only be used for a single attempt.
+	return &rewindableReader{Reader: io.MultiReader(bytes.NewReader(buf), rd)}, nil
+}
+
+// rewind seeks back to the beginning so the reader can be replayed. Readers
+// that were too large to buffer have no seeker and can only be rewound
+// once, before the first attempt; any further call fails the retry.
+func (r *rewindableReader) rewind() error {
+	if r.seeker == nil {
+		if r.rewound {
+			return errors.New("body too large to retry")
+		}
+		r.rewound = true
+		return nil
+	}
+	_, err := r.seeker.Seek(0, io.SeekStart)
+	return err
+}
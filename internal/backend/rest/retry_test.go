@@ -0,0 +1,131 @@
+package rest
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestIsRetryableStatusCode(t *testing.T) {
+	cases := map[int]bool{
+		200: false,
+		400: false,
+		404: false,
+		429: true,
+		500: true,
+		502: true,
+		503: true,
+		504: true,
+	}
+
+	for code, want := range cases {
+		if got := isRetryableStatusCode(code); got != want {
+			t.Errorf("isRetryableStatusCode(%d) = %v, want %v", code, got, want)
+		}
+	}
+}
+
+func TestRetryDelayRetryAfter(t *testing.T) {
+	cfg := NewConfig()
+	cfg.RetryBackoffCap = time.Minute
+
+	resp := &http.Response{Header: http.Header{"Retry-After": []string{"5"}}}
+	if got := retryDelay(cfg, 0, resp); got != 5*time.Second {
+		t.Errorf("retryDelay() = %v, want %v", got, 5*time.Second)
+	}
+}
+
+func TestDoWithRetryRecovers(t *testing.T) {
+	cfg := NewConfig()
+	cfg.MaxRetries = 3
+	cfg.RetryBackoff = time.Millisecond
+	cfg.RetryBackoffCap = time.Millisecond
+
+	attempts := 0
+	resp, err := doWithRetry(context.Background(), cfg, func() (*http.Response, error) {
+		attempts++
+		if attempts < 3 {
+			return &http.Response{StatusCode: http.StatusServiceUnavailable, Body: ioutil.NopCloser(bytes.NewReader(nil))}, nil
+		}
+		return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(bytes.NewReader(nil))}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if attempts != 3 {
+		t.Errorf("got %d attempts, want 3", attempts)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("got status %v, want 200", resp.StatusCode)
+	}
+}
+
+func TestRewindableReaderBuffersSmallBody(t *testing.T) {
+	data := []byte("hello world")
+
+	r, err := newRewindableReader(ioutil.NopCloser(bytes.NewReader(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatalf("got %q, want %q", got, data)
+	}
+
+	if err := r.rewind(); err != nil {
+		t.Fatal(err)
+	}
+	if got, err = ioutil.ReadAll(r); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("after rewind: got %q, want %q", got, data)
+	}
+}
+
+func TestRewindableReaderRejectsSecondRewindOfOversizedBody(t *testing.T) {
+	data := bytes.Repeat([]byte("x"), maxRewindableBufferSize+1)
+
+	r, err := newRewindableReader(ioutil.NopCloser(bytes.NewReader(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.rewind(); err != nil {
+		t.Fatalf("first rewind (before any read) should succeed, got %v", err)
+	}
+
+	if _, err := ioutil.ReadAll(r); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.rewind(); err == nil {
+		t.Error("expected rewind() to fail for an oversized body that can't be replayed")
+	}
+}
+
+func TestDoWithRetryTerminalError(t *testing.T) {
+	cfg := NewConfig()
+	cfg.MaxRetries = 3
+
+	attempts := 0
+	resp, _ := doWithRetry(context.Background(), cfg, func() (*http.Response, error) {
+		attempts++
+		return &http.Response{StatusCode: http.StatusNotFound, Body: ioutil.NopCloser(bytes.NewReader(nil))}, nil
+	})
+
+	if attempts != 1 {
+		t.Errorf("got %d attempts for a 404, want 1 (not retryable)", attempts)
+	}
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("got status %v, want 404", resp.StatusCode)
+	}
+}